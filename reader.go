@@ -0,0 +1,571 @@
+package multipart
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// maxFormValueBytes bounds the size of a single non-file form value
+// read by ReadForm, mirroring the stdlib's mime/multipart package.
+const maxFormValueBytes = 10 << 20
+
+// MIMEHeader is a parsed set of part headers, keyed by canonicalized
+// header name. It is intentionally shaped like net/textproto.MIMEHeader
+// so it is a drop-in replacement in code that already works with
+// mime/multipart, without this package importing net/textproto itself.
+type MIMEHeader map[string][]string
+
+// Get returns the first value associated with the given key, or the
+// empty string if there is none.
+func (h MIMEHeader) Get(key string) string {
+	v := h[canonicalHeaderKey(key)]
+	if len(v) == 0 {
+		return ""
+	}
+
+	return v[0]
+}
+
+// Set sets the header entry associated with key to the single value.
+func (h MIMEHeader) Set(key, value string) {
+	h[canonicalHeaderKey(key)] = []string{value}
+}
+
+// Add appends value to the values associated with key.
+func (h MIMEHeader) Add(key, value string) {
+	key = canonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+func canonicalHeaderKey(s string) string {
+	b := []byte(s)
+	upper := true
+
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+
+		upper = c == '-'
+	}
+
+	return string(b)
+}
+
+func chopTerminator(line []byte) (content, term []byte) {
+	switch {
+	case bytes.HasSuffix(line, []byte("\r\n")):
+		return line[:len(line)-2], line[len(line)-2:]
+	case bytes.HasSuffix(line, []byte("\n")):
+		return line[:len(line)-1], line[len(line)-1:]
+	default:
+		return line, nil
+	}
+}
+
+// scanBufSize bounds how much of a part's body FormReader looks at in
+// one pass while hunting for the next boundary. Content is scanned and
+// emitted in chunks of up to this size, so a part body with no boundary
+// match anywhere in it (e.g. a multi-GB file) is never buffered in full
+// to find the delimiter.
+const scanBufSize = 32 * 1024
+
+// FormReader parses a multipart/form-data (or multipart/mixed) body.
+//
+// It mirrors the stdlib mime/multipart.Reader's NextPart/ReadForm API,
+// but is named FormReader rather than Reader because this package
+// already exports Reader as the Writer's part-body interface.
+type FormReader struct {
+	br             *bufio.Reader
+	dashBoundary   []byte // "--boundary", used to recognize the first boundary line while skipping the preamble
+	finalBoundary  []byte // "--boundary--"
+	nlDashBoundary []byte // "\n--boundary", searched for directly in part bodies
+
+	partsRead int
+	curPart   *Part
+
+	partDone bool
+	sawFinal bool
+}
+
+// NewFormReader creates a new FormReader reading from r, splitting it
+// into parts using the given MIME boundary, which is found in the
+// Content-Type header as the boundary parameter (without the leading
+// "--").
+func NewFormReader(r io.Reader, boundary string) *FormReader {
+	b := "--" + boundary
+
+	return &FormReader{
+		br:             bufio.NewReaderSize(r, scanBufSize),
+		dashBoundary:   []byte(b),
+		finalBoundary:  []byte(b + "--"),
+		nlDashBoundary: []byte("\n" + b),
+	}
+}
+
+// NextPart returns the next part in the multipart body, or io.EOF when
+// there are no more parts. Any part previously returned is drained of
+// unread content before advancing.
+func (r *FormReader) NextPart() (*Part, error) {
+	if r.curPart != nil {
+		if _, err := io.Copy(io.Discard, r.curPart); err != nil {
+			return nil, err
+		}
+
+		r.curPart = nil
+	}
+
+	if r.sawFinal {
+		return nil, io.EOF
+	}
+
+	if r.partsRead == 0 {
+		if err := r.skipPreamble(); err != nil {
+			return nil, err
+		}
+
+		if r.sawFinal {
+			return nil, io.EOF
+		}
+	}
+
+	header, err := r.readHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	r.partsRead++
+	r.partDone = false
+
+	p := &Part{Header: header, r: r}
+	p.parseContentDisposition()
+
+	r.curPart = p
+
+	return p, nil
+}
+
+// skipPreamble discards everything up to and including the stream's
+// first boundary line, per RFC 2046's allowance for preamble text
+// before the first part.
+func (r *FormReader) skipPreamble() error {
+	for {
+		line, err := r.br.ReadBytes('\n')
+		content, _ := chopTerminator(line)
+		content = bytes.TrimRight(content, " \t")
+
+		if bytes.Equal(content, r.dashBoundary) {
+			return nil
+		}
+
+		if bytes.Equal(content, r.finalBoundary) {
+			r.sawFinal = true
+			return nil
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("multipart: no boundary found in body")
+			}
+
+			return err
+		}
+	}
+}
+
+// readHeaders reads "Key: Value" header lines, with RFC 822-style
+// folded continuation lines, up to the blank line that ends a part's
+// header block.
+func (r *FormReader) readHeaders() (MIMEHeader, error) {
+	h := make(MIMEHeader)
+
+	var lastKey string
+
+	for {
+		line, err := r.br.ReadBytes('\n')
+		content, _ := chopTerminator(line)
+
+		if len(content) == 0 {
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+
+			return h, nil
+		}
+
+		if (content[0] == ' ' || content[0] == '\t') && lastKey != "" {
+			if v := h[lastKey]; len(v) > 0 {
+				v[len(v)-1] += " " + strings.TrimSpace(string(content))
+			}
+
+			continue
+		}
+
+		i := bytes.IndexByte(content, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("multipart: malformed part header: %q", content)
+		}
+
+		key := canonicalHeaderKey(string(content[:i]))
+		h.Add(key, strings.TrimSpace(string(content[i+1:])))
+		lastKey = key
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			return nil, err
+		}
+	}
+}
+
+// readPartChunk returns the next chunk of the current part's body, or
+// io.EOF once the next boundary line has been consumed. It searches for
+// the boundary over a bounded peek window instead of buffering whole
+// lines, so a part body with no '\n' before its boundary (a large binary
+// upload, say) can't force this reader to buffer it in full.
+func (r *FormReader) readPartChunk() ([]byte, error) {
+	if r.partDone {
+		return nil, io.EOF
+	}
+
+	peek, peekErr := r.br.Peek(scanBufSize)
+
+	if i := bytes.Index(peek, r.nlDashBoundary); i >= 0 {
+		content := peek[:i]
+		if bytes.HasSuffix(content, []byte("\r")) {
+			content = content[:len(content)-1]
+		}
+
+		chunk := append([]byte(nil), content...)
+
+		if _, err := r.br.Discard(i + len(r.nlDashBoundary)); err != nil {
+			r.partDone = true
+			return chunk, err
+		}
+
+		r.partDone = true
+
+		if err := r.consumeBoundaryTail(); err != nil {
+			return chunk, err
+		}
+
+		return chunk, io.EOF
+	}
+
+	if peekErr != nil {
+		r.partDone = true
+
+		if !errors.Is(peekErr, io.EOF) {
+			return nil, peekErr
+		}
+
+		if len(peek) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if _, err := r.br.Discard(len(peek)); err != nil {
+			return nil, err
+		}
+
+		return append([]byte(nil), peek...), io.ErrUnexpectedEOF
+	}
+
+	// No boundary in this window: emit everything except the last
+	// len(nlDashBoundary) bytes, which could be the start of a boundary
+	// split across this fill and the next. The extra byte beyond
+	// len(nlDashBoundary)-1 keeps a potential CRLF's '\r' held back too,
+	// since nlDashBoundary itself only starts at '\n' and the '\r'
+	// preceding it is stripped separately, after a match is found.
+	safe := len(peek) - len(r.nlDashBoundary)
+	chunk := append([]byte(nil), peek[:safe]...)
+
+	if _, err := r.br.Discard(safe); err != nil {
+		r.partDone = true
+		return chunk, err
+	}
+
+	return chunk, nil
+}
+
+// consumeBoundaryTail consumes what follows a matched boundary: the
+// optional "--" suffix marking the final boundary, any transport padding,
+// and the line's terminating CRLF.
+func (r *FormReader) consumeBoundaryTail() error {
+	if suffix, err := r.br.Peek(2); err == nil && bytes.Equal(suffix, []byte("--")) {
+		r.sawFinal = true
+
+		if _, err := r.br.Discard(2); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.br.ReadBytes('\n'); err != nil {
+		if errors.Is(err, io.EOF) {
+			if r.sawFinal {
+				return nil
+			}
+
+			return io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Part is a single part of a multipart body, as returned by
+// FormReader.NextPart.
+type Part struct {
+	// Header holds the part's MIME headers.
+	Header MIMEHeader
+
+	formName string
+	fileName string
+
+	r   *FormReader
+	buf []byte
+	err error
+}
+
+func (p *Part) parseContentDisposition() {
+	v := p.Header.Get("Content-Disposition")
+	if v == "" {
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return
+	}
+
+	p.formName = params["name"]
+	p.fileName = params["filename"]
+}
+
+// FormName returns the name parameter of the part's Content-Disposition
+// header, or the empty string if the part has none.
+func (p *Part) FormName() string {
+	return p.formName
+}
+
+// FileName returns the filename parameter of the part's
+// Content-Disposition header, or the empty string if the part is not a
+// file. mime.ParseMediaType decodes the RFC 5987/2231 filename* form
+// transparently, so callers don't need to special-case it.
+func (p *Part) FileName() string {
+	return p.fileName
+}
+
+// Read reads the part's body. It returns io.EOF once the next boundary
+// has been reached.
+func (p *Part) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 && p.err == nil {
+		chunk, err := p.r.readPartChunk()
+		p.buf = chunk
+		p.err = err
+	}
+
+	if len(p.buf) == 0 {
+		return 0, p.err
+	}
+
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+
+	if len(p.buf) == 0 && p.err != nil {
+		return n, p.err
+	}
+
+	return n, nil
+}
+
+// Close discards the rest of the part's body.
+func (p *Part) Close() error {
+	_, err := io.Copy(io.Discard, p)
+	return err
+}
+
+// Form is a parsed multipart form, as returned by FormReader.ReadForm.
+type Form struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+// RemoveAll removes any temporary files associated with the form's
+// file parts. A Form's caller should always defer this once done with
+// the form.
+func (f *Form) RemoveAll() error {
+	var err error
+
+	for _, fhs := range f.File {
+		for _, fh := range fhs {
+			if fh.tmpfile == "" {
+				continue
+			}
+
+			if e := os.Remove(fh.tmpfile); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	return err
+}
+
+// FileHeader describes a file part of a multipart form.
+type FileHeader struct {
+	Filename string
+	Header   MIMEHeader
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is the interface returned by FileHeader.Open, satisfied by
+// either an in-memory section or a spilled temp file.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the file's contents, from memory or from the spilled
+// temp file on disk.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+
+	return &memFile{bytes.NewReader(fh.content)}, nil
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+// ReadForm reads the whole multipart body, keeping up to maxMemory
+// bytes of file parts in memory and spilling the rest to temp files
+// created with os.CreateTemp, the same strategy the stdlib's
+// mime/multipart.Reader.ReadForm uses.
+func (r *FormReader) ReadForm(maxMemory int64) (*Form, error) {
+	form := &Form{
+		Value: make(map[string][]string),
+		File:  make(map[string][]*FileHeader),
+	}
+
+	remaining := maxMemory
+
+	for {
+		part, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			value, err := readFormValue(part)
+			if err != nil {
+				form.RemoveAll()
+				return nil, err
+			}
+
+			form.Value[name] = append(form.Value[name], value)
+
+			continue
+		}
+
+		fh, err := readFormFile(part, &remaining)
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		form.File[name] = append(form.File[name], fh)
+	}
+
+	return form, nil
+}
+
+func readFormValue(p *Part) (string, error) {
+	var buf bytes.Buffer
+
+	n, err := io.Copy(&buf, io.LimitReader(p, maxFormValueBytes+1))
+	if err != nil {
+		return "", err
+	}
+
+	if n > maxFormValueBytes {
+		return "", errors.New("multipart: form value too large")
+	}
+
+	return buf.String(), nil
+}
+
+func readFormFile(p *Part, remaining *int64) (*FileHeader, error) {
+	fh := &FileHeader{
+		Filename: p.FileName(),
+		Header:   p.Header,
+	}
+
+	var buf bytes.Buffer
+
+	n, err := io.CopyN(&buf, p, *remaining+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if n <= *remaining {
+		fh.content = buf.Bytes()
+		fh.Size = n
+		*remaining -= n
+
+		return fh, nil
+	}
+
+	f, err := os.CreateTemp("", "multipart-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	written, err := io.Copy(f, p)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	fh.tmpfile = f.Name()
+	fh.Size = n + written
+	*remaining = 0
+
+	return fh, nil
+}
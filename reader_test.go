@@ -0,0 +1,224 @@
+package multipart
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestFormReaderNextPart(t *testing.T) {
+	var b bytes.Buffer
+
+	w := multipart.NewWriter(&b)
+
+	part, err := w.CreateFormFile("myfile", "my-file.txt")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write([]byte("my file contents"))
+
+	if err := w.WriteField("key", "val"); err != nil {
+		t.Fatalf("WriteField: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r := NewFormReader(&b, w.Boundary())
+
+	p1, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart: %v", err)
+	}
+
+	if got := p1.FormName(); got != "myfile" {
+		t.Fatalf("FormName() = %q, want %q", got, "myfile")
+	}
+
+	if got := p1.FileName(); got != "my-file.txt" {
+		t.Fatalf("FileName() = %q, want %q", got, "my-file.txt")
+	}
+
+	data, err := io.ReadAll(p1)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	if string(data) != "my file contents" {
+		t.Fatalf("part body = %q, want %q", data, "my file contents")
+	}
+
+	p2, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart: %v", err)
+	}
+
+	if got := p2.FormName(); got != "key" {
+		t.Fatalf("FormName() = %q, want %q", got, "key")
+	}
+
+	data, err = io.ReadAll(p2)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	if string(data) != "val" {
+		t.Fatalf("part body = %q, want %q", data, "val")
+	}
+
+	if _, err := r.NextPart(); err != io.EOF {
+		t.Fatalf("NextPart() err = %v, want io.EOF", err)
+	}
+}
+
+func TestFormReaderNextPartLargeBinaryBody(t *testing.T) {
+	body := bytes.Repeat([]byte{0xFF}, scanBufSize*3+17)
+
+	var b bytes.Buffer
+
+	w := multipart.NewWriter(&b)
+
+	part, err := w.CreateFormFile("myfile", "my-file.bin")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write(body)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r := NewFormReader(&b, w.Boundary())
+
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart: %v", err)
+	}
+
+	data, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	if !bytes.Equal(data, body) {
+		t.Fatalf("part body length = %d, want %d", len(data), len(body))
+	}
+
+	if _, err := r.NextPart(); err != io.EOF {
+		t.Fatalf("NextPart() err = %v, want io.EOF", err)
+	}
+}
+
+// TestFormReaderNextPartScanWindowBoundary sweeps part-body lengths
+// around the end of the first scan window, using a fixed-length
+// boundary so the window edge is exactly predictable. readPartChunk
+// consumes scanBufSize-len(nlDashBoundary) bytes per window when no
+// delimiter is found, so a body of exactly that many bytes puts the
+// '\r' of the CRLF preceding the boundary at the very edge of the
+// "safe to emit" region; that must never leak into the decoded body.
+func TestFormReaderNextPartScanWindowBoundary(t *testing.T) {
+	boundary := strings.Repeat("b", 40)
+	nlDashLen := len("\n--" + boundary)
+	period := scanBufSize - nlDashLen
+
+	for delta := -(nlDashLen + 5); delta <= 5; delta++ {
+		length := period + delta
+		if length < 0 {
+			continue
+		}
+
+		t.Run(fmt.Sprintf("len=%d", length), func(t *testing.T) {
+			body := bytes.Repeat([]byte{'A'}, length)
+
+			var b bytes.Buffer
+
+			w := multipart.NewWriter(&b)
+
+			if err := w.SetBoundary(boundary); err != nil {
+				t.Fatalf("SetBoundary: %v", err)
+			}
+
+			part, err := w.CreateFormFile("myfile", "my-file.bin")
+			if err != nil {
+				t.Fatalf("CreateFormFile: %v", err)
+			}
+			part.Write(body)
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			r := NewFormReader(&b, w.Boundary())
+
+			p, err := r.NextPart()
+			if err != nil {
+				t.Fatalf("NextPart: %v", err)
+			}
+
+			data, err := io.ReadAll(p)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if !bytes.Equal(data, body) {
+				t.Fatalf("part body length = %d, want %d (data: %q)", len(data), len(body), data)
+			}
+		})
+	}
+}
+
+func TestFormReaderReadForm(t *testing.T) {
+	var b bytes.Buffer
+
+	w := multipart.NewWriter(&b)
+
+	part, err := w.CreateFormFile("myfile", "my-file.txt")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write([]byte("my file contents"))
+
+	if err := w.WriteField("key", "val"); err != nil {
+		t.Fatalf("WriteField: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r := NewFormReader(&b, w.Boundary())
+
+	form, err := r.ReadForm(1024)
+	if err != nil {
+		t.Fatalf("ReadForm: %v", err)
+	}
+	defer form.RemoveAll()
+
+	if got := form.Value["key"]; len(got) != 1 || got[0] != "val" {
+		t.Fatalf("form.Value[%q] = %v, want [val]", "key", got)
+	}
+
+	fhs := form.File["myfile"]
+	if len(fhs) != 1 {
+		t.Fatalf("form.File[%q] has %d entries, want 1", "myfile", len(fhs))
+	}
+
+	f, err := fhs[0].Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	if string(data) != "my file contents" {
+		t.Fatalf("file contents = %q, want %q", data, "my file contents")
+	}
+}
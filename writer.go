@@ -3,8 +3,12 @@ package multipart
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"mime/quotedprintable"
+	"os"
 	"strings"
 )
 
@@ -14,44 +18,116 @@ type Reader interface {
 	Len() int
 }
 
-type header struct {
-	key   string
-	value string
+// Header is a single MIME header field on a part, in the order it
+// should be written. Unlike textproto.MIMEHeader, it preserves
+// caller-supplied ordering and allows repeated keys.
+type Header struct {
+	Key   string
+	Value string
 }
 
 type part struct {
-	headers []header
+	headers []Header
 	body    Reader
 }
 
+// WriterOptions configures optional behavior of a Writer beyond its
+// zero-value defaults.
+type WriterOptions struct {
+	// SpillDir is the directory in which CreateFormFileStream buffers
+	// a stream-backed part once it grows past SpillThreshold. Empty
+	// means the default directory returned by os.TempDir.
+	SpillDir string
+
+	// SpillThreshold is the number of bytes CreateFormFileStream will
+	// buffer in memory before spilling the rest of the stream to a
+	// temp file in SpillDir. Zero (the default) disables spilling:
+	// stream-backed parts are instead sent using chunked framing and
+	// Len() reports -1.
+	SpillThreshold int64
+
+	// RandSource is read to generate the boundary. Nil (the default)
+	// uses crypto/rand.Reader. Set this to a deterministic source
+	// (e.g. a seeded math/rand.Rand wrapped in a reader) for hermetic
+	// tests and benchmarks, or in environments where crypto/rand is
+	// restricted.
+	RandSource io.Reader
+}
+
 // A Writer generates multipart messages.
 type Writer struct {
 	boundary []byte
 	parts    []part
+	options  WriterOptions
+
+	// spillFiles holds the temp files CreateFormFileStream created via
+	// spillToDisk, so Close/Reset can release their fds and remove
+	// them; files the caller handed in via FileReader are theirs to
+	// manage and are never touched here.
+	spillFiles []*os.File
 
 	writePosition int
 	sysBuf        bytes.Buffer
 }
 
 // NewWriter returns a new multipart Writer with a random boundary.
-func NewWriter() *Writer {
+func NewWriter() (*Writer, error) {
+	return NewWriterWithOptions(WriterOptions{})
+}
+
+// NewWriterWithOptions returns a new multipart Writer with a random
+// boundary, configured per opts.
+func NewWriterWithOptions(opts WriterOptions) (*Writer, error) {
 	w := &Writer{
 		boundary:      make([]byte, 60),
 		parts:         make([]part, 0, 8),
+		options:       opts,
 		writePosition: -1,
 	}
 
-	w.randomBoundary()
+	if err := w.randomBoundary(); err != nil {
+		return nil, err
+	}
 
-	return w
+	return w, nil
 }
 
-func (w *Writer) Reset() {
-	w.randomBoundary()
+// Reset clears the Writer's parts and generates a new random boundary,
+// so it can be reused for another message. Any temp files spilled by a
+// prior CreateFormFileStream call are closed and removed.
+func (w *Writer) Reset() error {
+	if err := w.randomBoundary(); err != nil {
+		return err
+	}
+
+	closeErr := w.closeSpillFiles()
+
 	w.parts = w.parts[:0]
 	w.writePosition = -1
 
 	w.sysBuf.Reset()
+
+	return closeErr
+}
+
+// closeSpillFiles closes and removes every temp file spillToDisk has
+// created on this Writer so far.
+func (w *Writer) closeSpillFiles() error {
+	var err error
+
+	for _, f := range w.spillFiles {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+
+		if e := os.Remove(f.Name()); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	w.spillFiles = w.spillFiles[:0]
+
+	return err
 }
 
 // Boundary returns the Writer's boundary.
@@ -111,10 +187,19 @@ func (w *Writer) FormDataContentType() string {
 
 const hextable = "0123456789abcdef"
 
-func (w *Writer) randomBoundary() {
-	_, err := io.ReadFull(rand.Reader, w.boundary[:30])
-	if err != nil {
-		panic(err)
+func (w *Writer) randomBoundary() error {
+	src := w.options.RandSource
+	if src == nil {
+		src = rand.Reader
+	}
+
+	// SetBoundary may have left w.boundary pointing at a caller-sized
+	// slice, so always reallocate to the size this method works in
+	// rather than index into whatever capacity happens to be there.
+	w.boundary = make([]byte, 60)
+
+	if _, err := io.ReadFull(src, w.boundary[:30]); err != nil {
+		return err
 	}
 
 	j := len(w.boundary) - 2
@@ -127,6 +212,8 @@ func (w *Writer) randomBoundary() {
 
 		j -= 2
 	}
+
+	return nil
 }
 
 var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
@@ -148,23 +235,22 @@ func (w *Writer) createPart(p part) error {
 // CreateFormFile creates a new form-data header with
 // the provided field name and file name and data.
 func (w *Writer) CreateFormFile(fieldname string, filename string, data []byte) error {
-	h := []header{
-		{"Content-Disposition", `form-data; name="` + escapeQuotes(fieldname) + `"; filename="` + escapeQuotes(filename) + `"`},
-		{"Content-Type", "application/octet-stream"},
-	}
-
-	return w.createPart(part{
-		headers: h,
-		body:    bytes.NewReader(data),
-	})
+	return w.CreateFormFileReaderWithType(fieldname, filename, "application/octet-stream", bytes.NewReader(data))
 }
 
 // CreateFormFileReader creates a new form-data header with
 // the provided field name and file name and reader.
 func (w *Writer) CreateFormFileReader(fieldname string, filename string, data Reader) error {
-	h := []header{
+	return w.CreateFormFileReaderWithType(fieldname, filename, "application/octet-stream", data)
+}
+
+// CreateFormFileReaderWithType creates a new form-data file part with
+// the provided field name, file name and reader, using contentType as
+// its Content-Type instead of the "application/octet-stream" default.
+func (w *Writer) CreateFormFileReaderWithType(fieldname string, filename string, contentType string, data Reader) error {
+	h := []Header{
 		{"Content-Disposition", `form-data; name="` + escapeQuotes(fieldname) + `"; filename="` + escapeQuotes(filename) + `"`},
-		{"Content-Type", "application/octet-stream"},
+		{"Content-Type", contentType},
 	}
 
 	return w.createPart(part{
@@ -176,7 +262,7 @@ func (w *Writer) CreateFormFileReader(fieldname string, filename string, data Re
 // CreateFormField creates part with a header using the
 // given field name and data.
 func (w *Writer) CreateFormField(fieldname string, data []byte) error {
-	h := []header{
+	h := []Header{
 		{"Content-Disposition", `form-data; name="` + escapeQuotes(fieldname) + `"`},
 	}
 
@@ -189,7 +275,7 @@ func (w *Writer) CreateFormField(fieldname string, data []byte) error {
 // CreateFormFieldReader creates part with a header using the
 // given field name and reader.
 func (w *Writer) CreateFormFieldReader(fieldname string, data Reader) error {
-	h := []header{
+	h := []Header{
 		{"Content-Disposition", `form-data; name="` + escapeQuotes(fieldname) + `"`},
 	}
 
@@ -199,6 +285,376 @@ func (w *Writer) CreateFormFieldReader(fieldname string, data Reader) error {
 	})
 }
 
+// Encoding identifies a Content-Transfer-Encoding that
+// CreateFormFieldEncoded can apply to a field's data.
+type Encoding int
+
+const (
+	// EncodingQuotedPrintable encodes data as quoted-printable, per
+	// RFC 2045 section 6.7.
+	EncodingQuotedPrintable Encoding = iota
+
+	// EncodingBase64 encodes data as base64, per RFC 2045 section 6.8.
+	EncodingBase64
+)
+
+// CreateFormFieldEncoded creates a part with the given field name
+// whose data is transfer-encoded per enc, for interop with multipart
+// consumers that expect Content-Transfer-Encoding on fields (e.g.
+// multipart/mixed mail-style payloads, or servers that decode binary
+// form fields). The part's Content-Transfer-Encoding header reflects
+// enc, and Len() accounts for the post-encoding length without
+// materializing the encoded bytes up front: the part's body encodes
+// data one bounded slice at a time, only as the Read pump asks for more.
+func (w *Writer) CreateFormFieldEncoded(fieldname string, data []byte, enc Encoding) error {
+	var transferEncoding string
+
+	switch enc {
+	case EncodingBase64:
+		transferEncoding = "base64"
+	case EncodingQuotedPrintable:
+		transferEncoding = "quoted-printable"
+	default:
+		return errors.New("multipart: unknown encoding")
+	}
+
+	body, err := newEncodedReader(data, enc)
+	if err != nil {
+		return err
+	}
+
+	h := []Header{
+		{"Content-Disposition", `form-data; name="` + escapeQuotes(fieldname) + `"`},
+		{"Content-Transfer-Encoding", transferEncoding},
+	}
+
+	return w.createPart(part{
+		headers: h,
+		body:    body,
+	})
+}
+
+const encodedReaderBufSize = 32 * 1024
+
+// encodedReader streams data through a base64 or quoted-printable
+// encoder lazily, one encodedReaderBufSize slice at a time, as its Read method is
+// called, so CreateFormFieldEncoded never has to materialize the whole
+// encoded payload up front for a large field. Len() is computed by a
+// pre-pass over data that mirrors the encoder's own output-size
+// formula, so it's known before a single byte has actually been
+// encoded.
+type encodedReader struct {
+	data   []byte
+	pos    int
+	length int
+
+	enc  io.WriteCloser
+	out  bytes.Buffer
+	done bool
+}
+
+func newEncodedReader(data []byte, enc Encoding) (*encodedReader, error) {
+	r := &encodedReader{data: data}
+
+	switch enc {
+	case EncodingBase64:
+		r.length = ((len(data) + 2) / 3) * 4
+		r.enc = base64.NewEncoder(base64.StdEncoding, &r.out)
+	case EncodingQuotedPrintable:
+		r.length = quotedPrintableLen(data)
+		r.enc = quotedprintable.NewWriter(&r.out)
+	default:
+		return nil, errors.New("multipart: unknown encoding")
+	}
+
+	return r, nil
+}
+
+func (r *encodedReader) Len() int { return r.length }
+
+func (r *encodedReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		end := r.pos + encodedReaderBufSize
+		if end > len(r.data) {
+			end = len(r.data)
+		}
+
+		if r.pos < end {
+			if _, err := r.enc.Write(r.data[r.pos:end]); err != nil {
+				return 0, err
+			}
+
+			r.pos = end
+		}
+
+		if r.pos >= len(r.data) {
+			if err := r.enc.Close(); err != nil {
+				return 0, err
+			}
+
+			r.done = true
+		}
+	}
+
+	return r.out.Read(p)
+}
+
+// quotedPrintableLen computes the length mime/quotedprintable.Writer
+// would produce for data without actually running it, by replaying its
+// line-length bookkeeping: each byte needing a "=XX" escape costs 3
+// bytes instead of 1, a soft line break ("=\r\n") is inserted whenever
+// a line would exceed 76 columns, and trailing whitespace immediately
+// before a line break is escaped rather than left bare.
+func quotedPrintableLen(data []byte) int {
+	const lineMaxLen = 76
+
+	var (
+		total  int
+		col    int
+		lastWS bool
+		cr     bool
+	)
+
+	softBreak := func() {
+		total += 3
+		col = 0
+		lastWS = false
+	}
+
+	escape := func() {
+		if lineMaxLen-1-col < 3 {
+			softBreak()
+		}
+
+		total += 3
+		col += 3
+		lastWS = false
+	}
+
+	literal := func(b byte) {
+		if col == lineMaxLen-1 {
+			softBreak()
+		}
+
+		total++
+		col++
+		lastWS = isQPWhitespace(b)
+		cr = false
+	}
+
+	checkLast := func() {
+		if col == 0 || !lastWS {
+			return
+		}
+
+		col--
+		total--
+		lastWS = false
+		escape()
+	}
+
+	newline := func(b byte) {
+		if cr && b == '\n' {
+			cr = false
+			return
+		}
+
+		cr = b == '\r'
+		checkLast()
+		total += 2
+		col = 0
+		lastWS = false
+	}
+
+	for _, b := range data {
+		switch {
+		case b >= '!' && b <= '~' && b != '=':
+			literal(b)
+		case isQPWhitespace(b):
+			literal(b)
+		case b == '\n' || b == '\r':
+			newline(b)
+		default:
+			escape()
+		}
+	}
+
+	checkLast()
+
+	return total
+}
+
+func isQPWhitespace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+// CreateFormFileStream creates a new form-data file part backed by an
+// io.Reader of unknown length.
+//
+// If the Writer has a non-zero WriterOptions.SpillThreshold, the
+// stream is buffered up to that many bytes in memory and, if it fills
+// that buffer, spilled to a temp file in WriterOptions.SpillDir; either
+// way Len() can still report an exact size. Otherwise the part is sent
+// using Content-Transfer-Encoding: chunked framing and Len() reports
+// -1, so callers must set Transfer-Encoding: chunked on the outer
+// request themselves.
+func (w *Writer) CreateFormFileStream(fieldname string, filename string, r io.Reader) error {
+	h := []Header{
+		{"Content-Disposition", `form-data; name="` + escapeQuotes(fieldname) + `"; filename="` + escapeQuotes(filename) + `"`},
+		{"Content-Type", "application/octet-stream"},
+	}
+
+	if w.options.SpillThreshold > 0 {
+		body, err := w.spillToDisk(r)
+		if err != nil {
+			return err
+		}
+
+		return w.createPart(part{headers: h, body: body})
+	}
+
+	h = append(h, Header{"Content-Transfer-Encoding", "chunked"})
+
+	return w.createPart(part{
+		headers: h,
+		body:    &chunkedReader{r: r},
+	})
+}
+
+// spillToDisk buffers r in memory up to SpillThreshold bytes; if r
+// fills that buffer (even with nothing left after it), the buffered
+// bytes and the rest of r go into a temp file so the full length is
+// still known up front.
+func (w *Writer) spillToDisk(r io.Reader) (Reader, error) {
+	buf := make([]byte, w.options.SpillThreshold)
+
+	n, err := io.ReadFull(r, buf)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return bytes.NewReader(buf[:n]), nil
+	case err != nil:
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(w.options.SpillDir, "multipart-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Write(buf[:n]); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, err
+	}
+
+	body, err := FileReader(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, err
+	}
+
+	w.spillFiles = append(w.spillFiles, f)
+
+	return body, nil
+}
+
+// FileReader returns a Reader whose Len() comes from f.Stat() and whose
+// WriteTo forwards to f, so that copying it through Writer.WriteTo lets
+// the kernel splice the file directly to destinations that support
+// ReadFrom (e.g. a *net.TCPConn) instead of churning through a
+// user-space copy loop.
+func FileReader(f *os.File) (Reader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileReader{f: f, size: fi.Size()}, nil
+}
+
+type fileReader struct {
+	f    *os.File
+	size int64
+}
+
+func (r *fileReader) Read(p []byte) (int, error) { return r.f.Read(p) }
+
+func (r *fileReader) Len() int { return int(r.size) }
+
+func (r *fileReader) WriteTo(dst io.Writer) (int64, error) { return io.Copy(dst, r.f) }
+
+const chunkedReaderBufSize = 32 * 1024
+
+// chunkedReader wraps a plain io.Reader of unknown length, yielding it
+// as HTTP chunked transfer coding so it can be embedded in a part body
+// whose final size isn't known up front.
+type chunkedReader struct {
+	r    io.Reader
+	buf  [chunkedReaderBufSize]byte
+	out  bytes.Buffer
+	done bool
+}
+
+func (c *chunkedReader) Len() int { return -1 }
+
+func (c *chunkedReader) Read(dst []byte) (int, error) {
+	for c.out.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+
+		n, err := c.r.Read(c.buf[:])
+		if n > 0 {
+			fmt.Fprintf(&c.out, "%x\r\n", n)
+			c.out.Write(c.buf[:n])
+			c.out.WriteString("\r\n")
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return 0, err
+			}
+
+			c.out.WriteString("0\r\n\r\n")
+			c.done = true
+		}
+	}
+
+	return c.out.Read(dst)
+}
+
+// CreatePart creates a new part with the given headers and body,
+// written in the given header order. Callers are responsible for
+// setting any Content-Disposition, Content-Type or other headers
+// they need; CreatePart does not add any of its own.
+func (w *Writer) CreatePart(headers []Header, body Reader) error {
+	return w.createPart(part{
+		headers: headers,
+		body:    body,
+	})
+}
+
 func (w *Writer) Read(dst []byte) (int, error) {
 	for {
 		if w.sysBuf.Len() > 0 {
@@ -242,9 +698,9 @@ func (w *Writer) Read(dst []byte) (int, error) {
 		p := w.parts[w.writePosition]
 
 		for _, p := range p.headers {
-			w.sysBuf.WriteString(p.key)
+			w.sysBuf.WriteString(p.Key)
 			w.sysBuf.WriteString(": ")
-			w.sysBuf.WriteString(p.value)
+			w.sysBuf.WriteString(p.Value)
 			w.sysBuf.WriteString("\r\n")
 		}
 
@@ -252,21 +708,104 @@ func (w *Writer) Read(dst []byte) (int, error) {
 	}
 }
 
+// WriteTo writes the full multipart message directly to dst, bypassing
+// sysBuf for each part body: if a part's body implements io.WriterTo
+// (e.g. the Reader returned by FileReader, or *bytes.Reader), its
+// WriteTo is used so the kernel can splice the bytes instead of
+// copying them through a user-space buffer one dst slice at a time.
+func (w *Writer) WriteTo(dst io.Writer) (int64, error) {
+	if len(w.parts) == 0 {
+		return 0, nil
+	}
+
+	var total int64
+
+	write := func(p []byte) error {
+		n, err := dst.Write(p)
+		total += int64(n)
+
+		return err
+	}
+
+	for i, p := range w.parts {
+		var head bytes.Buffer
+
+		if i != 0 {
+			head.WriteString("\r\n")
+		}
+		head.WriteString("--")
+		head.Write(w.boundary)
+		head.WriteString("\r\n")
+
+		for _, h := range p.headers {
+			head.WriteString(h.Key)
+			head.WriteString(": ")
+			head.WriteString(h.Value)
+			head.WriteString("\r\n")
+		}
+		head.WriteString("\r\n")
+
+		if err := write(head.Bytes()); err != nil {
+			return total, err
+		}
+
+		if wt, ok := p.body.(io.WriterTo); ok {
+			n, err := wt.WriteTo(dst)
+			total += n
+
+			if err != nil {
+				return total, err
+			}
+
+			continue
+		}
+
+		n, err := io.Copy(dst, p.body)
+		total += n
+
+		if err != nil {
+			return total, err
+		}
+	}
+
+	var tail bytes.Buffer
+
+	tail.WriteString("\r\n--")
+	tail.Write(w.boundary)
+	tail.WriteString("--\r\n")
+
+	if err := write(tail.Bytes()); err != nil {
+		return total, err
+	}
+
+	return total, nil
+}
+
+// Close releases any temp files spilled by CreateFormFileStream. It
+// does not touch readers the caller supplied directly (including via
+// FileReader), which remain the caller's responsibility.
 func (w *Writer) Close() error {
-	return nil
+	return w.closeSpillFiles()
 }
 
+// Len returns the total encoded size of the message, or -1 if any part
+// has a body of unknown length (see CreateFormFileStream).
 func (w *Writer) Len() int {
 	l := 0
 
 	for _, v := range w.parts {
+		bl := v.body.Len()
+		if bl < 0 {
+			return -1
+		}
+
 		l += 4 + len(w.boundary)
 
 		for _, p := range v.headers {
-			l += 4 + len(p.key) + len(p.value)
+			l += 4 + len(p.Key) + len(p.Value)
 		}
 
-		l += 4 + v.body.Len()
+		l += 4 + bl
 	}
 
 	if l > 0 {
@@ -6,6 +6,8 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -37,7 +39,10 @@ func TestCompare(t *testing.T) {
 		t.Fatalf("Close: %v", err)
 	}
 
-	w2 := NewWriter()
+	w2, err := NewWriter()
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
 
 	w2.SetBoundary([]byte(w1.Boundary()))
 	w2.CreateFormFileReader("myfile", "my-file.txt", bytes.NewReader(fileContents))
@@ -74,7 +79,11 @@ func TestEicar(t *testing.T) {
 
 	defer fileResp.Body.Close()
 
-	w2 := NewWriter()
+	w2, err := NewWriter()
+	if err != nil {
+		panic(err)
+	}
+
 	scanID, _ := uuid.NewUUID()
 
 	err = w2.CreateFormFileReader("myfile", "my-file.txt", NewReader(fileResp.Body, int(fileResp.ContentLength)))
@@ -107,6 +116,268 @@ func TestEicar(t *testing.T) {
 	}
 }
 
+func TestCreateFormFileStreamChunked(t *testing.T) {
+	w, err := NewWriter()
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+
+	if err := w.CreateFormFileStream("myfile", "my-file.txt", strings.NewReader("my file contents")); err != nil {
+		t.Fatalf("CreateFormFileStream: %v", err)
+	}
+
+	if got := w.Len(); got != -1 {
+		t.Fatalf("Len() = %d, want -1", got)
+	}
+
+	var b bytes.Buffer
+
+	if _, err := io.Copy(&b, w); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if !bytes.Contains(b.Bytes(), []byte("Content-Transfer-Encoding: chunked")) {
+		t.Fatalf("output missing chunked transfer encoding header: %q", b.String())
+	}
+
+	if !bytes.Contains(b.Bytes(), []byte("10\r\nmy file contents\r\n0\r\n\r\n")) {
+		t.Fatalf("output missing chunked body framing: %q", b.String())
+	}
+}
+
+func TestCreateFormFileStreamSpill(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{SpillThreshold: 4})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions: %v", err)
+	}
+
+	if err := w.CreateFormFileStream("myfile", "my-file.txt", strings.NewReader("my file contents")); err != nil {
+		t.Fatalf("CreateFormFileStream: %v", err)
+	}
+
+	if got := w.Len(); got < len("my file contents") {
+		t.Fatalf("Len() = %d, want a known size covering the spilled body", got)
+	}
+
+	var b bytes.Buffer
+
+	if _, err := io.Copy(&b, w); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if !bytes.Contains(b.Bytes(), []byte("my file contents")) {
+		t.Fatalf("output missing spilled file contents: %q", b.String())
+	}
+
+	if len(w.spillFiles) != 1 {
+		t.Fatalf("len(w.spillFiles) = %d, want 1", len(w.spillFiles))
+	}
+
+	spillPath := w.spillFiles[0].Name()
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if _, err := os.Stat(spillPath); !os.IsNotExist(err) {
+		t.Fatalf("Close() left the spilled temp file behind: %v", err)
+	}
+}
+
+func TestWriteToFileReader(t *testing.T) {
+	tmp, err := os.CreateTemp(t.TempDir(), "multipart-test-*")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+
+	if _, err := tmp.WriteString("my file contents"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+
+	fr, err := FileReader(tmp)
+	if err != nil {
+		t.Fatalf("FileReader: %v", err)
+	}
+
+	w, err := NewWriter()
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+
+	w.SetBoundary([]byte("testboundary"))
+
+	if err := w.CreateFormFileReader("myfile", "my-file.txt", fr); err != nil {
+		t.Fatalf("CreateFormFileReader: %v", err)
+	}
+
+	if err := w.CreateFormField("key", []byte("val")); err != nil {
+		t.Fatalf("CreateFormField: %v", err)
+	}
+
+	var viaRead, viaWriteTo bytes.Buffer
+
+	if _, err := io.Copy(&viaRead, w); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if err := w.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	w.SetBoundary([]byte("testboundary"))
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+
+	w.CreateFormFileReader("myfile", "my-file.txt", fr)
+	w.CreateFormField("key", []byte("val"))
+
+	if _, err := w.WriteTo(&viaWriteTo); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	if !bytes.Equal(viaRead.Bytes(), viaWriteTo.Bytes()) {
+		t.Fatalf("WriteTo output differs from Read output:\nRead:    %q\nWriteTo: %q", viaRead.String(), viaWriteTo.String())
+	}
+}
+
+func TestCreateFormFieldEncoded(t *testing.T) {
+	tests := []struct {
+		name             string
+		enc              Encoding
+		data             []byte
+		wantTransferEnc  string
+		wantBodyContains string
+	}{
+		{
+			name:             "base64",
+			enc:              EncodingBase64,
+			data:             []byte("my file contents"),
+			wantTransferEnc:  "base64",
+			wantBodyContains: "bXkgZmlsZSBjb250ZW50cw==",
+		},
+		{
+			name:             "quoted-printable",
+			enc:              EncodingQuotedPrintable,
+			data:             []byte("caf\xc3\xa9="),
+			wantTransferEnc:  "quoted-printable",
+			wantBodyContains: "caf=C3=A9=3D",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := NewWriter()
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+
+			if err := w.CreateFormFieldEncoded("field", tt.data, tt.enc); err != nil {
+				t.Fatalf("CreateFormFieldEncoded: %v", err)
+			}
+
+			want := w.Len()
+
+			var b bytes.Buffer
+
+			n, err := io.Copy(&b, w)
+			if err != nil {
+				t.Fatalf("Copy: %v", err)
+			}
+
+			if int(n) != want {
+				t.Fatalf("Copy wrote %d bytes, Len() reported %d", n, want)
+			}
+
+			if !bytes.Contains(b.Bytes(), []byte("Content-Transfer-Encoding: "+tt.wantTransferEnc)) {
+				t.Fatalf("output missing Content-Transfer-Encoding: %s header: %q", tt.wantTransferEnc, b.String())
+			}
+
+			if !bytes.Contains(b.Bytes(), []byte(tt.wantBodyContains)) {
+				t.Fatalf("output missing encoded body %q: %q", tt.wantBodyContains, b.String())
+			}
+		})
+	}
+}
+
+func TestCreatePart(t *testing.T) {
+	w, err := NewWriter()
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+
+	data := []byte("custom part body")
+
+	err = w.CreatePart([]Header{
+		{"X-Custom-Header", "custom-value"},
+		{"Content-Type", "text/plain"},
+	}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+
+	want := w.Len()
+
+	var b bytes.Buffer
+
+	n, err := io.Copy(&b, w)
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if int(n) != want {
+		t.Fatalf("Copy wrote %d bytes, Len() reported %d", n, want)
+	}
+
+	if !bytes.Contains(b.Bytes(), []byte("X-Custom-Header: custom-value")) {
+		t.Fatalf("output missing custom header: %q", b.String())
+	}
+
+	if !bytes.Contains(b.Bytes(), data) {
+		t.Fatalf("output missing part body: %q", b.String())
+	}
+}
+
+func TestCreateFormFileReaderWithType(t *testing.T) {
+	w, err := NewWriter()
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+
+	data := []byte("my file contents")
+
+	err = w.CreateFormFileReaderWithType("myfile", "my-file.json", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("CreateFormFileReaderWithType: %v", err)
+	}
+
+	want := w.Len()
+
+	var b bytes.Buffer
+
+	n, err := io.Copy(&b, w)
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if int(n) != want {
+		t.Fatalf("Copy wrote %d bytes, Len() reported %d", n, want)
+	}
+
+	if !bytes.Contains(b.Bytes(), []byte("Content-Type: application/json")) {
+		t.Fatalf("output missing Content-Type header: %q", b.String())
+	}
+
+	if !bytes.Contains(b.Bytes(), data) {
+		t.Fatalf("output missing file contents: %q", b.String())
+	}
+}
+
 func BenchmarkStd(b *testing.B) {
 	b1 := bytes.NewBuffer(nil)
 
@@ -139,13 +410,20 @@ func BenchmarkStd(b *testing.B) {
 }
 
 func BenchmarkThis(b *testing.B) {
-	w2 := NewWriter()
+	w2, err := NewWriter()
+	if err != nil {
+		b.Fatalf("NewWriter: %v", err)
+	}
+
 	b2 := bytes.NewBuffer(nil)
 	fileContents := []byte("my file contents")
 
 	for i := 0; i < b.N; i++ {
 		b2.Reset()
-		w2.Reset()
+
+		if err := w2.Reset(); err != nil {
+			b.Fatalf("Reset: %v", err)
+		}
 
 		w2.CreateFormFile("myfile", "my-file.txt", fileContents)
 		w2.CreateFormField("key", []byte("val"))